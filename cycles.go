@@ -0,0 +1,190 @@
+package main
+
+/*
+Copyright 2014 Graham King
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+For full license details see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// tarjan finds the strongly connected components of the graph held in the
+// package-level `deps` map, using Tarjan's algorithm. Components of size 1
+// are not cycles (unless a package imports itself) and are omitted.
+type tarjan struct {
+	index   map[string]int
+	lowlink map[string]int
+	onStack map[string]bool
+	stack   []string
+	counter int
+	sccs    [][]string
+}
+
+func newTarjan() *tarjan {
+	return &tarjan{
+		index:   make(map[string]int),
+		lowlink: make(map[string]int),
+		onStack: make(map[string]bool),
+	}
+}
+
+func (t *tarjan) run() [][]string {
+	// Sort for deterministic output across runs.
+	paths := make([]string, 0, len(deps))
+	for path := range deps {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		if _, seen := t.index[path]; !seen {
+			t.strongConnect(path)
+		}
+	}
+	return t.sccs
+}
+
+func (t *tarjan) strongConnect(v string) {
+	t.index[v] = t.counter
+	t.lowlink[v] = t.counter
+	t.counter++
+	t.stack = append(t.stack, v)
+	t.onStack[v] = true
+
+	imports := deps[v]
+	sort.Slice(imports, func(i, j int) bool { return imports[i].PkgPath < imports[j].PkgPath })
+	for _, w := range imports {
+		wp := w.PkgPath
+		if _, seen := t.index[wp]; !seen {
+			t.strongConnect(wp)
+			if t.lowlink[wp] < t.lowlink[v] {
+				t.lowlink[v] = t.lowlink[wp]
+			}
+		} else if t.onStack[wp] {
+			if t.index[wp] < t.lowlink[v] {
+				t.lowlink[v] = t.index[wp]
+			}
+		}
+	}
+
+	if t.lowlink[v] == t.index[v] {
+		var scc []string
+		for {
+			n := len(t.stack) - 1
+			w := t.stack[n]
+			t.stack = t.stack[:n]
+			t.onStack[w] = false
+			scc = append(scc, w)
+			if w == v {
+				break
+			}
+		}
+		t.sccs = append(t.sccs, scc)
+	}
+}
+
+var cycleStackRe = regexp.MustCompile(`import cycle not allowed: import stack: \[(.*)\]`)
+
+// parseCycleImportStack extracts the import stack from a "go list"
+// "import cycle not allowed" error message, e.g. "import cycle not
+// allowed: import stack: [a b a]" -> ["a", "b", "a"].
+func parseCycleImportStack(msg string) ([]string, bool) {
+	m := cycleStackRe.FindStringSubmatch(msg)
+	if m == nil {
+		return nil, false
+	}
+	return strings.Fields(m[1]), true
+}
+
+// loadCycleImportStacks scans pkgs and their already-resolved imports for
+// "import cycle not allowed" errors the go list driver attaches when a
+// genuine cycle blocks the module from loading at all - the one case
+// buildGraph/tarjan never get a chance to see, since packages.Load aborts
+// the cyclic branch before deps has a graph to walk. Returns each
+// reported cycle's import stack, deduped.
+func loadCycleImportStacks(pkgs []*packages.Package) [][]string {
+	seen := make(map[string]bool)
+	visited := make(map[string]bool)
+	var stacks [][]string
+
+	var walk func(p *packages.Package)
+	walk = func(p *packages.Package) {
+		if visited[p.PkgPath] {
+			return
+		}
+		visited[p.PkgPath] = true
+		for _, e := range p.Errors {
+			stack, ok := parseCycleImportStack(e.Msg)
+			if !ok {
+				continue
+			}
+			key := strings.Join(stack, " ")
+			if !seen[key] {
+				seen[key] = true
+				stacks = append(stacks, stack)
+			}
+		}
+		for _, imp := range p.Imports {
+			walk(imp)
+		}
+	}
+	for _, p := range pkgs {
+		walk(p)
+	}
+	return stacks
+}
+
+// reportLoadCycles prints any import cycles the loader reported while
+// resolving pkgs and reports whether it found one. Called before the
+// normal graph walk, since a genuine cycle stops packages.Load from
+// building a graph for tarjan to look at in the first place.
+func reportLoadCycles(pkgs []*packages.Package) bool {
+	stacks := loadCycleImportStacks(pkgs)
+	if len(stacks) == 0 {
+		return false
+	}
+	sort.Slice(stacks, func(i, j int) bool {
+		return strings.Join(stacks[i], " ") < strings.Join(stacks[j], " ")
+	})
+	fmt.Println("Import cycles (reported by the loader; a cycle stops a full graph from being built):")
+	for _, stack := range stacks {
+		fmt.Println(" ", strings.Join(stack, " -> "))
+	}
+	return true
+}
+
+// cyclesDisplay prints the strongly connected components of more than one
+// package found in the currently analyzed graph, i.e. the genuine import
+// cycles.
+func cyclesDisplay() {
+	sccs := newTarjan().run()
+	found := false
+	for _, scc := range sccs {
+		if len(scc) < 2 {
+			continue
+		}
+		found = true
+		sort.Strings(scc)
+		fmt.Println(" ", scc)
+	}
+	if !found {
+		fmt.Println("No import cycles found")
+	}
+}
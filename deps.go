@@ -20,25 +20,58 @@ For full license details see <http://www.gnu.org/licenses/>.
 import (
 	"flag"
 	"fmt"
-	"go/build"
 	"log"
 	"os"
+	"runtime"
 	"sort"
 	"strings"
+
+	"golang.org/x/tools/go/packages"
 )
 
-const usage = `USAGE: deps <package> [-display deep|count|layers|depth -lib -stdlib -short]
+const usage = `USAGE: deps <package-pattern> [-d deep|count|layers|depth|cycles|licenses|diff -lib -stdlib -short -tags tag,list]
 "deps" prints the internal dependencies of a Go package.
 
--d deep|layers|count|depth  Display more / different information
+<package-pattern> is anything golang.org/x/tools/go/packages accepts:
+a single import path, a relative path, or a pattern like "./..." that
+expands to every package in the current module.
+
+-d deep|layers|count|depth|cycles|licenses|diff  Display more / different information
  deep: print the dependencies of the dependencies, recursively.
  count: show the packages organised by how many imports they have
  layers: display the top-down dependency layers
  depth: display the bottom-up dependency layers
+ cycles: report import cycles. A genuine cycle between two non-test
+  packages stops "go list" from building a full graph at all, so this
+  parses the import stack out of its "import cycle not allowed" error
+  instead of relying on the graph; anything that still loads is also
+  checked for strongly connected components of more than one package, via
+  Tarjan's algorithm, as a second pass over whatever graph did get built.
+  Cycles reachable only through _test.go files are not analyzed.
+ licenses: print a package | module | license | confidence table built by
+  scanning each dependency's module directory for a LICENSE/COPYING file
+  and classifying it with github.com/google/licensecheck. Combine with
+  -format json to use deps as a license bill-of-materials generator.
+ diff: compare the current dependency graph against a base, given by
+  -base <git-ref> (checked out into a temporary git worktree) or
+  -base <snapshot.json> (as produced by -d deps -format json), and print
+  added/removed packages, added/removed edges, and layer changes.
+
+-rules <file>  Path to a JSON or YAML policy file of the form
+ { "deny": [{"from": "pkg/api", "to": "pkg/internal/db"}],
+   "allow_only": {"pkg/api": ["pkg/models"]} }
+ Every edge in the dependency graph is checked against the policy;
+ deps exits non-zero and lists the violations if any edge is denied,
+ or if a package has allow_only rules and imports something not listed.
+ Useful as an architecture-fitness check in CI.
+
+-base <git-ref>|<snapshot.json>  Base graph to compare against, with -d diff.
+ A git ref is checked out into a temporary worktree and re-analyzed; a
+ snapshot.json is the output of a previous "-d deps -format json" run.
 
 -lib  Include libraries.
- By default deps ignores anything starting with github.com, bitbucket.org, etc,
- because those are libraries and you only care about your app. Add this flag
+ By default deps ignores anything outside the current module, because
+ those are libraries and you only care about your app. Add this flag
  to prevent this ignoring.
 
 -stdlib  Include Go built-in packages.
@@ -48,35 +81,49 @@ const usage = `USAGE: deps <package> [-display deep|count|layers|depth -lib -std
 -short  Trim the package you are analyzing off the front of dependencies.
  e.g.: github.com/coreos/etcd/config -> config.
 
-<package> is a path exactly like you would use in your code in "import".
-That package and all it's dependencies must be on findable (GOPATH or stdlib).
+-tags  Comma-separated list of build tags to pass to the loader, same as
+ "go build -tags". Combine with GOOS/GOARCH env vars to analyze a
+ cross-compiled variant of the package.
+
+-workers  Number of goroutines used to walk the import graph concurrently.
+ Defaults to runtime.NumCPU(). Each package is still loaded/classified
+ exactly once, however many workers are running.
+
+-format text|json|dot|mermaid  Emit the dependency graph for piping into
+ other tools instead of the human-readable -d display.
+ json: one object per package with path, imports, layer, depth, numDeps.
+ dot: a directed graph for "dot -Tpng" / graphviz.
+ mermaid: a "flowchart TD" block for embedding in Markdown.
+
+<package-pattern> must resolve inside a Go module (or GOPATH package);
+"deps" uses the same loader as the "go" tool itself, so module replace
+directives and vendoring are respected.
 `
 
 var (
-	thirdPartyRoots = []string{
-		"github.com",
-		"bitbucket.org",
-		"launchpad.net",
-		"code.google.com",
-	}
-	display         = flag.String("d", "deps", "Display format: deep|layers|count|depth")
+	display         = flag.String("d", "deps", "Display format: deep|layers|count|depth|cycles|licenses|diff")
 	isHelp          = flag.Bool("h", false, "Display this help")
 	isIncludeStdlib = flag.Bool("stdlib", false, "Include standard library packages")
 	isIncludeLibs   = flag.Bool("lib", false, "Include third-party library packages")
 	isShort         = flag.Bool("short", false, "Trim current package name from dependencies")
+	buildTags       = flag.String("tags", "", "Comma-separated build tags to pass to the loader")
+	format          = flag.String("format", "text", "Output format: text|json|dot|mermaid")
+	rulesPath       = flag.String("rules", "", "Path to a JSON/YAML dependency policy file")
+	baseRef         = flag.String("base", "", "Git ref or -format json snapshot file to diff against, with -d diff")
 	rootPackage     string
-	deps            map[string][]*build.Package
+	mainModule      string
+	workers         = flag.Int("workers", runtime.NumCPU(), "Number of concurrent workers to walk the graph with")
+	deps            map[string][]*packages.Package
 	numDeps         map[string]int
 	layerPos        map[string]int
 	depth           map[string]int
 	lowestLayer     int
 	maxDeps         int
-	progress        int
 )
 
 func main() {
 	if len(os.Args) < 2 {
-		fmt.Println(usage)
+		fmt.Print(usage)
 		os.Exit(1)
 	}
 
@@ -84,115 +131,118 @@ func main() {
 	rootPackage = os.Args[1]
 
 	if *isHelp {
-		fmt.Println(usage)
+		fmt.Print(usage)
 		os.Exit(1)
 	}
 
-	numDeps = make(map[string]int)
-	deps = make(map[string][]*build.Package)
-	layerPos = make(map[string]int)
-	depth = make(map[string]int)
+	cfg := &packages.Config{
+		Mode: packages.NeedName | packages.NeedImports | packages.NeedDeps | packages.NeedModule,
+	}
+	if *buildTags != "" {
+		cfg.BuildFlags = []string{"-tags", *buildTags}
+	}
 
-	fmt.Println("Dependencies of", bold(rootPackage))
-	pkg, err := build.Import(rootPackage, "", 0)
+	pkgs, err := packages.Load(cfg, rootPackage)
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	analyze(pkg, 0)
-	os.Stdout.Write([]byte("                                     \r"))
-	os.Stdout.Sync()
-
-	switch *display {
-	case "layers":
-		fmt.Println("Top-down dependency layers")
-		fmt.Println("Number after package name is number of imports")
-		layerDisplay()
-	case "depth":
-		fmt.Println("Bottom-up dependency layers")
-		fmt.Println("Number after package name is number of imports")
-		depthDisplay(rootPackage)
-	case "deep":
-		fmt.Println("Dependency tree")
-		deepDepsDisplay(rootPackage, 0)
-	case "count":
-		fmt.Println("Packages by descending number of internal imports")
-		countDisplay()
-	default:
-		depsDisplay(rootPackage)
+	if *display == "cycles" && reportLoadCycles(pkgs) {
+		os.Exit(1)
 	}
-}
-
-func analyze(pkg *build.Package, layer int) int {
-	os.Stdout.Write([]byte(fmt.Sprintf("Working ... %d   \r", progress)))
-	progress++
-	os.Stdout.Sync()
-
-	if layer > lowestLayer {
-		lowestLayer = layer
+	if packages.PrintErrors(pkgs) > 0 {
+		os.Exit(1)
+	}
+	if len(pkgs) == 0 {
+		log.Fatalf("no packages matched %q", rootPackage)
 	}
-	path := pkg.ImportPath
-	if val, ok := layerPos[path]; ok && layer <= val {
-		// We've already found this package at a deeper layer
-		return depth[path]
+	if pkgs[0].Module != nil {
+		mainModule = pkgs[0].Module.Path
 	}
 
-	layerPos[path] = layer
+	var graphs []rootGraph
 
-	var ours []*build.Package
-	for _, p := range pkg.Imports {
-		if p == "C" {
+	for _, pkg := range pkgs {
+		numDeps = make(map[string]int)
+		deps = make(map[string][]*packages.Package)
+		layerPos = make(map[string]int)
+		depth = make(map[string]int)
+		lowestLayer = 0
+		maxDeps = 0
+
+		if *format == "text" {
+			fmt.Println("Dependencies of", bold(pkg.PkgPath))
+		}
+
+		if *display == "licenses" {
+			licensesDisplay(pkg)
 			continue
 		}
-		innerPkg, err := build.Import(p, "", 0)
-		if err != nil {
-			log.Fatal(err)
+
+		fmt.Fprint(os.Stderr, "Working ...\r")
+		buildGraph(pkg, *workers)
+		computeLayers(pkg.PkgPath)
+		computeDepths(pkg.PkgPath)
+		fmt.Fprint(os.Stderr, "             \r")
+
+		if *display == "diff" {
+			diffDisplay(pkg, rootPackage)
+			continue
 		}
-		if !isStdlib(innerPkg) && !isThirdParty(innerPkg) {
-			ours = append(ours, innerPkg)
+
+		if *format != "text" {
+			graphs = append(graphs, rootGraph{Root: pkg.PkgPath, Nodes: graphNodes()})
+			continue
 		}
-	}
 
-	numDeps[path] = len(ours)
-	if len(ours) > maxDeps {
-		maxDeps = len(ours)
-	}
-	deps[path] = ours
+		switch *display {
+		case "layers":
+			fmt.Println("Top-down dependency layers")
+			fmt.Println("Number after package name is number of imports")
+			layerDisplay()
+		case "depth":
+			fmt.Println("Bottom-up dependency layers")
+			fmt.Println("Number after package name is number of imports")
+			depthDisplay(pkg.PkgPath)
+		case "deep":
+			fmt.Println("Dependency tree")
+			deepDepsDisplay(pkg.PkgPath, 0)
+		case "count":
+			fmt.Println("Packages by descending number of internal imports")
+			countDisplay()
+		case "cycles":
+			fmt.Println("Import cycles (strongly connected components)")
+			cyclesDisplay()
+		default:
+			depsDisplay(pkg.PkgPath)
+		}
 
-	var ourDepth int
-	for _, innerPkg := range ours {
-		d := analyze(innerPkg, layer+1)
-		if d > ourDepth {
-			ourDepth = d
+		if *rulesPath != "" {
+			checkRules(*rulesPath)
 		}
 	}
-	if len(ours) != 0 {
-		ourDepth++
+
+	if *format != "text" && len(graphs) > 0 {
+		formatDisplay(rootPackage, graphs)
 	}
-	depth[path] = ourDepth
-	return ourDepth
 }
 
-func isStdlib(p *build.Package) bool {
+func isStdlib(p *packages.Package) bool {
 	if *isIncludeStdlib {
 		return false
 	}
-	return p.Goroot
+	// Packages with no module are either standard library, or the
+	// synthetic "unsafe" / "C" pseudo-packages, none of which are ours.
+	return p.Module == nil
 }
 
-func isThirdParty(p *build.Package) bool {
+func isThirdParty(p *packages.Package) bool {
 	if *isIncludeLibs {
 		return false
 	}
-	if strings.HasPrefix(p.ImportPath, rootPackage) {
+	if p.Module == nil {
 		return false
 	}
-	for _, root := range thirdPartyRoots {
-		if strings.HasPrefix(p.ImportPath, root) {
-			return true
-		}
-	}
-	return false
+	return p.Module.Path != mainModule
 }
 
 func depsDisplay(pkgName string) {
@@ -200,7 +250,7 @@ func depsDisplay(pkgName string) {
 		fmt.Println("No internal dependencies")
 	}
 	for _, pkg := range deps[pkgName] {
-		fmt.Println(" ", short(pkg.ImportPath))
+		fmt.Println(" ", short(pkg.PkgPath))
 	}
 }
 
@@ -208,7 +258,7 @@ func deepDepsDisplay(pkgName string, depth int) {
 	indent := strings.Repeat("| ", depth)
 	fmt.Printf("%s%s\n", indent, short(pkgName))
 	for _, pkg := range deps[pkgName] {
-		deepDepsDisplay(pkg.ImportPath, depth+1)
+		deepDepsDisplay(pkg.PkgPath, depth+1)
 	}
 }
 
@@ -0,0 +1,265 @@
+package main
+
+/*
+Copyright 2014 Graham King
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+For full license details see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// graphDiff is the result of comparing two dependency graphs, suitable for
+// printing as text or, with -format json, for a PR bot to parse.
+type graphDiff struct {
+	AddedPackages   []string `json:"addedPackages"`
+	RemovedPackages []string `json:"removedPackages"`
+	AddedEdges      []string `json:"addedEdges"`
+	RemovedEdges    []string `json:"removedEdges"`
+	LayerChanges    []string `json:"layerChanges"`
+}
+
+// diffDisplay compares the already-built graph for pkg against the base
+// named by -base, and prints what changed. pattern is the raw package
+// pattern the user gave on the command line, re-resolved against the base
+// revision when -base is a git ref.
+func diffDisplay(pkg *packages.Package, pattern string) {
+	if *baseRef == "" {
+		log.Fatal("-d diff requires -base <git-ref> or -base <snapshot.json>")
+	}
+
+	cur := graphNodes()
+
+	var base []pkgNode
+	if data, err := os.ReadFile(*baseRef); err == nil {
+		if err := json.Unmarshal(data, &base); err != nil {
+			log.Fatalf("parsing -base snapshot %s: %v", *baseRef, err)
+		}
+	} else {
+		b, err := loadGraphAtRef(*baseRef, pattern, pkg.PkgPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		base = b
+	}
+
+	d := computeDiff(base, cur)
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(d); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	fmt.Printf("Diff against %s\n", *baseRef)
+	printSection("Added packages", d.AddedPackages)
+	printSection("Removed packages", d.RemovedPackages)
+	printSection("Added edges", d.AddedEdges)
+	printSection("Removed edges", d.RemovedEdges)
+	printSection("Layer changes", d.LayerChanges)
+}
+
+func printSection(title string, lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+	fmt.Println(title + ":")
+	for _, l := range lines {
+		fmt.Println(" ", l)
+	}
+}
+
+// computeDiff compares two pkgNode snapshots and reports added/removed
+// packages, added/removed import edges, and layer-position changes for
+// packages present in both.
+func computeDiff(base, cur []pkgNode) graphDiff {
+	baseByPath := make(map[string]pkgNode, len(base))
+	for _, n := range base {
+		baseByPath[n.Path] = n
+	}
+	curByPath := make(map[string]pkgNode, len(cur))
+	for _, n := range cur {
+		curByPath[n.Path] = n
+	}
+
+	var d graphDiff
+	for path := range curByPath {
+		if _, ok := baseByPath[path]; !ok {
+			d.AddedPackages = append(d.AddedPackages, path)
+		}
+	}
+	for path := range baseByPath {
+		if _, ok := curByPath[path]; !ok {
+			d.RemovedPackages = append(d.RemovedPackages, path)
+		}
+	}
+
+	baseEdges := edgeSet(base)
+	curEdges := edgeSet(cur)
+	for e := range curEdges {
+		if !baseEdges[e] {
+			d.AddedEdges = append(d.AddedEdges, e)
+		}
+	}
+	for e := range baseEdges {
+		if !curEdges[e] {
+			d.RemovedEdges = append(d.RemovedEdges, e)
+		}
+	}
+
+	for path, curNode := range curByPath {
+		baseNode, ok := baseByPath[path]
+		if ok && baseNode.Layer != curNode.Layer {
+			d.LayerChanges = append(d.LayerChanges,
+				fmt.Sprintf("%s: layer %d -> %d", path, baseNode.Layer, curNode.Layer))
+		}
+	}
+
+	sort.Strings(d.AddedPackages)
+	sort.Strings(d.RemovedPackages)
+	sort.Strings(d.AddedEdges)
+	sort.Strings(d.RemovedEdges)
+	sort.Strings(d.LayerChanges)
+	return d
+}
+
+func edgeSet(nodes []pkgNode) map[string]bool {
+	edges := make(map[string]bool)
+	for _, n := range nodes {
+		for _, imp := range n.Imports {
+			edges[fmt.Sprintf("%s -> %s", n.Path, imp)] = true
+		}
+	}
+	return edges
+}
+
+// loadGraphAtRef checks out ref into a temporary git worktree and builds
+// the dependency graph there, re-resolving pattern against that checkout.
+// pattern commonly expands to more than one root (e.g. "./..."), so root
+// picks out which of the resulting packages to build the graph from - the
+// one diffDisplay's current iteration is actually diffing.
+// It saves and restores the package-level graph state around the nested
+// build, since buildGraph/computeLayers/computeDepths write to the same
+// globals the caller is already using for the current revision.
+//
+// Every failure is returned rather than logged fatally, so the deferred
+// worktree/tmpdir cleanup below always runs before loadGraphAtRef returns -
+// an os.Exit from inside this function would skip it and leak the
+// worktree registration and its directory.
+func loadGraphAtRef(ref, pattern, root string) ([]pkgNode, error) {
+	repoRoot, err := exec.Command("git", "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return nil, fmt.Errorf("-base %s: not inside a git repo: %w", ref, err)
+	}
+	repoRootDir := string(trimNewline(repoRoot))
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	relCwd, err := filepath.Rel(repoRootDir, cwd)
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s relative to repo root %s: %w", cwd, repoRootDir, err)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "deps-diff-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	worktree := exec.Command("git", "worktree", "add", "--detach", "--force", tmpDir, ref)
+	worktree.Dir = repoRootDir
+	if out, err := worktree.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("git worktree add %s: %w\n%s", ref, err, out)
+	}
+	defer func() {
+		remove := exec.Command("git", "worktree", "remove", "--force", tmpDir)
+		remove.Dir = repoRootDir
+		remove.Run()
+	}()
+
+	// pattern was resolved by the user against their own cwd (e.g. "." from
+	// pkg/sub); re-resolve it the same way against the matching directory
+	// inside the worktree, not the worktree's root.
+	cfg := &packages.Config{
+		Dir:  filepath.Join(tmpDir, relCwd),
+		Mode: packages.NeedName | packages.NeedImports | packages.NeedDeps | packages.NeedModule,
+	}
+	if *buildTags != "" {
+		cfg.BuildFlags = []string{"-tags", *buildTags}
+	}
+	pkgs, err := packages.Load(cfg, pattern)
+	if err != nil {
+		return nil, fmt.Errorf("loading %s at %s: %w", pattern, ref, err)
+	}
+	if packages.PrintErrors(pkgs) > 0 || len(pkgs) == 0 {
+		return nil, fmt.Errorf("no packages matched %q at %s", pattern, ref)
+	}
+
+	// pattern commonly matches several roots (e.g. "./..."); pick out the
+	// one this diff is actually for, not whichever packages.Load happened
+	// to return first.
+	var rootPkg *packages.Package
+	for _, p := range pkgs {
+		if p.PkgPath == root {
+			rootPkg = p
+			break
+		}
+	}
+	if rootPkg == nil {
+		return nil, fmt.Errorf("%q not found among packages matching %q at %s", root, pattern, ref)
+	}
+
+	savedDeps, savedNumDeps, savedLayerPos, savedDepth := deps, numDeps, layerPos, depth
+	savedLowestLayer, savedMaxDeps, savedMainModule := lowestLayer, maxDeps, mainModule
+	defer func() {
+		deps, numDeps, layerPos, depth = savedDeps, savedNumDeps, savedLayerPos, savedDepth
+		lowestLayer, maxDeps, mainModule = savedLowestLayer, savedMaxDeps, savedMainModule
+	}()
+
+	numDeps = make(map[string]int)
+	deps = make(map[string][]*packages.Package)
+	layerPos = make(map[string]int)
+	depth = make(map[string]int)
+	lowestLayer = 0
+	maxDeps = 0
+	if rootPkg.Module != nil {
+		mainModule = rootPkg.Module.Path
+	}
+
+	buildGraph(rootPkg, *workers)
+	computeLayers(rootPkg.PkgPath)
+	computeDepths(rootPkg.PkgPath)
+
+	return graphNodes(), nil
+}
+
+func trimNewline(b []byte) []byte {
+	for len(b) > 0 && (b[len(b)-1] == '\n' || b[len(b)-1] == '\r') {
+		b = b[:len(b)-1]
+	}
+	return b
+}
@@ -0,0 +1,151 @@
+package main
+
+/*
+Copyright 2014 Graham King
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+For full license details see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// pkgNode is the machine-readable representation of one analyzed package,
+// used by the json/dot/mermaid formats.
+type pkgNode struct {
+	Path    string   `json:"path"`
+	Imports []string `json:"imports"`
+	Layer   int      `json:"layer"`
+	Depth   int      `json:"depth"`
+	NumDeps int      `json:"numDeps"`
+}
+
+// graphNodes builds the pkgNode list from the populated deps/numDeps/layerPos/depth
+// maps, sorted by path so output is stable across runs.
+func graphNodes() []pkgNode {
+	nodes := make([]pkgNode, 0, len(deps))
+	for path, d := range deps {
+		imports := make([]string, 0, len(d))
+		for _, p := range d {
+			imports = append(imports, p.PkgPath)
+		}
+		sort.Strings(imports)
+		nodes = append(nodes, pkgNode{
+			Path:    path,
+			Imports: imports,
+			Layer:   layerPos[path],
+			Depth:   depth[path],
+			NumDeps: numDeps[path],
+		})
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Path < nodes[j].Path })
+	return nodes
+}
+
+// rootGraph is the graph computed for a single matched root package, kept
+// alongside its root so multi-root patterns (e.g. "./...") can be merged
+// into one document instead of printed as several back-to-back ones.
+type rootGraph struct {
+	Root  string    `json:"root"`
+	Nodes []pkgNode `json:"nodes"`
+}
+
+// jsonDisplay prints graphs as one JSON document. A single root prints as
+// a bare array of pkgNode, matching the pre-multi-root output; more than
+// one root prints as an array of {root, nodes} so the document stays
+// valid JSON instead of several concatenated top-level arrays.
+func jsonDisplay(graphs []rootGraph) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	var err error
+	if len(graphs) == 1 {
+		err = enc.Encode(graphs[0].Nodes)
+	} else {
+		err = enc.Encode(graphs)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// dotDisplay merges every root's edges into a single digraph, deduping
+// edges shared between roots.
+func dotDisplay(name string, graphs []rootGraph) {
+	fmt.Printf("digraph %q {\n", name)
+	seen := make(map[string]bool)
+	for _, g := range graphs {
+		for _, n := range g.Nodes {
+			for _, imp := range n.Imports {
+				edge := n.Path + "->" + imp
+				if seen[edge] {
+					continue
+				}
+				seen[edge] = true
+				fmt.Printf("  %q -> %q;\n", n.Path, imp)
+			}
+		}
+	}
+	fmt.Println("}")
+}
+
+// mermaidDisplay merges every root's edges into a single flowchart,
+// deduping edges shared between roots.
+func mermaidDisplay(graphs []rootGraph) {
+	fmt.Println("flowchart TD")
+	seen := make(map[string]bool)
+	for _, g := range graphs {
+		for _, n := range g.Nodes {
+			id := mermaidID(n.Path)
+			for _, imp := range n.Imports {
+				edge := n.Path + "->" + imp
+				if seen[edge] {
+					continue
+				}
+				seen[edge] = true
+				fmt.Printf("  %s[%q] --> %s[%q]\n", id, n.Path, mermaidID(imp), imp)
+			}
+		}
+	}
+}
+
+var mermaidIDRe = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// mermaidID turns an import path into a Mermaid-safe node identifier;
+// the human-readable path is kept as the node's bracketed label.
+func mermaidID(path string) string {
+	return "n" + mermaidIDRe.ReplaceAllString(path, "_")
+}
+
+// formatDisplay renders every root's already-populated graph as one
+// combined document, in one of the machine-readable formats. Called
+// instead of the text display switch in main when -format is anything
+// other than "text". name is used as the digraph/flowchart title when
+// graphs holds more than one root.
+func formatDisplay(name string, graphs []rootGraph) {
+	switch strings.ToLower(*format) {
+	case "json":
+		jsonDisplay(graphs)
+	case "dot":
+		dotDisplay(name, graphs)
+	case "mermaid":
+		mermaidDisplay(graphs)
+	default:
+		log.Fatalf("unknown -format %q, want text|json|dot|mermaid", *format)
+	}
+}
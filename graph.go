@@ -0,0 +1,205 @@
+package main
+
+/*
+Copyright 2014 Graham King
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+For full license details see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"sort"
+	"sync"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// workQueue is a FIFO queue of packages still to classify, shared by a
+// fixed pool of workers. pending counts items that are either sitting in
+// the queue or claimed by a worker and still being processed; it reaches
+// zero exactly when there is no more work left anywhere, which is how
+// pop signals workers to exit instead of blocking forever.
+type workQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	items   []*packages.Package
+	pending int
+}
+
+func newWorkQueue() *workQueue {
+	q := &workQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *workQueue) push(pkg *packages.Package) {
+	q.mu.Lock()
+	q.items = append(q.items, pkg)
+	q.pending++
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// pop blocks until a package is available, or returns ok=false once the
+// queue is empty and nothing is still being processed.
+func (q *workQueue) pop() (pkg *packages.Package, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.items) == 0 {
+		if q.pending == 0 {
+			return nil, false
+		}
+		q.cond.Wait()
+	}
+	pkg, q.items = q.items[0], q.items[1:]
+	return pkg, true
+}
+
+// done marks one previously popped package as finished. Call exactly once
+// per successful pop, after any of its children have been pushed.
+func (q *workQueue) done() {
+	q.mu.Lock()
+	q.pending--
+	drained := q.pending == 0
+	q.mu.Unlock()
+	if drained {
+		q.cond.Broadcast()
+	}
+}
+
+// buildGraph walks the package graph reachable from root, populating
+// deps/numDeps/maxDeps. A fixed pool of workers drains a shared workQueue,
+// pushing each package's unclaimed internal imports back onto it; a
+// sync.Map of claimed import paths ensures each package is classified
+// exactly once no matter how many workers reach it. No worker ever blocks
+// waiting for another worker's slot, so the pool can't deadlock at any
+// recursion depth. Unlike the old recursive walk, this does no
+// layer/depth bookkeeping - that's a second pass, see computeLayers and
+// computeDepths.
+func buildGraph(root *packages.Package, workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	var claimed sync.Map // import path -> struct{}
+	var mu sync.Mutex    // guards deps/numDeps/maxDeps
+
+	q := newWorkQueue()
+	q.push(root)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				pkg, ok := q.pop()
+				if !ok {
+					return
+				}
+
+				path := pkg.PkgPath
+				if _, alreadyClaimed := claimed.LoadOrStore(path, struct{}{}); alreadyClaimed {
+					q.done()
+					continue
+				}
+
+				var ours []*packages.Package
+				for impPath, innerPkg := range pkg.Imports {
+					if impPath == "C" {
+						continue
+					}
+					if !isStdlib(innerPkg) && !isThirdParty(innerPkg) {
+						ours = append(ours, innerPkg)
+					}
+				}
+				sort.Slice(ours, func(i, j int) bool { return ours[i].PkgPath < ours[j].PkgPath })
+
+				mu.Lock()
+				numDeps[path] = len(ours)
+				if len(ours) > maxDeps {
+					maxDeps = len(ours)
+				}
+				deps[path] = ours
+				mu.Unlock()
+
+				for _, innerPkg := range ours {
+					q.push(innerPkg)
+				}
+				q.done()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// computeLayers does a breadth-first pass over the graph buildGraph already
+// populated, recording each package's shallowest distance from root in
+// layerPos. This is deliberately sequential: the expensive I/O-bound part
+// (loading and classifying packages) already happened in buildGraph, so
+// there's nothing left here worth parallelizing.
+func computeLayers(root string) {
+	layerPos[root] = 0
+	queue := []string{root}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		layer := layerPos[cur]
+		if layer > lowestLayer {
+			lowestLayer = layer
+		}
+		for _, child := range deps[cur] {
+			path := child.PkgPath
+			if existing, ok := layerPos[path]; !ok || layer+1 < existing {
+				layerPos[path] = layer + 1
+				queue = append(queue, path)
+			}
+		}
+	}
+}
+
+// computeDepths is a post-order pass over the graph recording, for each
+// package, the length of its longest chain of internal imports. A package
+// that's part of an import cycle is not recursed into a second time - it
+// contributes a depth of 0 to whichever caller is still computing it.
+func computeDepths(root string) {
+	computing := make(map[string]bool)
+
+	var visit func(path string) int
+	visit = func(path string) int {
+		if d, ok := depth[path]; ok {
+			return d
+		}
+		if computing[path] {
+			return 0
+		}
+		computing[path] = true
+		defer delete(computing, path)
+
+		children := deps[path]
+		var maxChild int
+		for _, child := range children {
+			if d := visit(child.PkgPath); d > maxChild {
+				maxChild = d
+			}
+		}
+
+		d := 0
+		if len(children) != 0 {
+			d = maxChild + 1
+		}
+		depth[path] = d
+		return d
+	}
+
+	visit(root)
+}
@@ -0,0 +1,111 @@
+package main
+
+/*
+Copyright 2014 Graham King
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+For full license details see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/google/licensecheck"
+	"golang.org/x/tools/go/packages"
+)
+
+// licenseFileNames are tried, in order, in a module's root directory.
+var licenseFileNames = []string{
+	"LICENSE", "LICENSE.txt", "LICENSE.md",
+	"LICENCE", "LICENCE.txt", "LICENCE.md",
+	"COPYING", "COPYING.txt",
+}
+
+// licenseRow is one line of the bill-of-materials: a package, the module it
+// belongs to, and the license detected in that module's source directory.
+type licenseRow struct {
+	Package    string  `json:"package"`
+	Module     string  `json:"module"`
+	License    string  `json:"license"`
+	Confidence float64 `json:"confidence"`
+}
+
+// licenseRows walks the full import graph rooted at pkg - including
+// standard library and third-party packages that -d deps would normally
+// filter out - and returns one row per distinct module reached.
+func licenseRows(pkg *packages.Package) []licenseRow {
+	seenModule := make(map[string]bool)
+	var rows []licenseRow
+
+	packages.Visit([]*packages.Package{pkg}, func(p *packages.Package) bool {
+		if p.Module == nil || seenModule[p.Module.Path] {
+			return true
+		}
+		seenModule[p.Module.Path] = true
+
+		name, confidence := classifyLicense(p.Module.Dir)
+		rows = append(rows, licenseRow{
+			Package:    p.PkgPath,
+			Module:     p.Module.Path,
+			License:    name,
+			Confidence: confidence,
+		})
+		return true
+	}, nil)
+
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Module < rows[j].Module })
+	return rows
+}
+
+// classifyLicense looks for a LICENSE-like file in dir and, if found, runs
+// it through licensecheck to name the license and report how much of the
+// file matched it.
+func classifyLicense(dir string) (name string, confidence float64) {
+	if dir == "" {
+		return "UNKNOWN", 0
+	}
+	for _, fileName := range licenseFileNames {
+		text, err := os.ReadFile(filepath.Join(dir, fileName))
+		if err != nil {
+			continue
+		}
+		cov := licensecheck.Scan(text)
+		if len(cov.Match) == 0 {
+			return "UNKNOWN", 0
+		}
+		return cov.Match[0].ID, cov.Percent
+	}
+	return "UNKNOWN", 0
+}
+
+func licensesDisplay(pkg *packages.Package) {
+	rows := licenseRows(pkg)
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rows); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	fmt.Printf("%-40s %-30s %-20s %s\n", "package", "module", "license", "confidence")
+	for _, r := range rows {
+		fmt.Printf("%-40s %-30s %-20s %.0f%%\n", r.Package, r.Module, r.License, r.Confidence)
+	}
+}
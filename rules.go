@@ -0,0 +1,128 @@
+package main
+
+/*
+Copyright 2014 Graham King
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+For full license details see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// rule is one "from imports to" denial in a policy file.
+type rule struct {
+	From string `json:"from" yaml:"from"`
+	To   string `json:"to" yaml:"to"`
+}
+
+// policy is the dependency-rule file loaded by -rules. A package matches a
+// rule's From/To fields if its import path equals that field or sits
+// inside the tree rooted at it (see pathMatch), so "pkg/internal/db"
+// denies the whole pkg/internal/db tree without also matching a sibling
+// like "pkg/internal/db2".
+type policy struct {
+	Deny      []rule              `json:"deny" yaml:"deny"`
+	AllowOnly map[string][]string `json:"allow_only" yaml:"allow_only"`
+}
+
+func loadPolicy(path string) (*policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var p policy
+	if strings.HasSuffix(path, ".json") {
+		err = json.Unmarshal(data, &p)
+	} else {
+		err = yaml.Unmarshal(data, &p)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return &p, nil
+}
+
+// checkRules loads the policy at path and reports every edge in the
+// already-populated `deps` graph that violates it, exiting non-zero if any
+// are found.
+func checkRules(path string) {
+	p, err := loadPolicy(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var violations []string
+	for from, imports := range deps {
+		for _, imp := range imports {
+			to := imp.PkgPath
+			if v := p.violation(from, to); v != "" {
+				violations = append(violations, v)
+			}
+		}
+	}
+	sort.Strings(violations)
+
+	if len(violations) == 0 {
+		fmt.Println("No dependency rule violations")
+		return
+	}
+
+	fmt.Println("Dependency rule violations:")
+	for _, v := range violations {
+		fmt.Println(" ", v)
+	}
+	os.Exit(1)
+}
+
+// violation returns a human-readable description if the from->to edge
+// breaks the policy, or "" if the edge is allowed.
+func (p *policy) violation(from, to string) string {
+	for _, d := range p.Deny {
+		if pathMatch(from, d.From) && pathMatch(to, d.To) {
+			return fmt.Sprintf("%s -> %s denied by rule {from: %s, to: %s}", from, to, d.From, d.To)
+		}
+	}
+	for pkgPrefix, allowed := range p.AllowOnly {
+		if !pathMatch(from, pkgPrefix) {
+			continue
+		}
+		ok := false
+		for _, a := range allowed {
+			if pathMatch(to, a) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fmt.Sprintf("%s -> %s not in allow_only list for %s", from, to, pkgPrefix)
+		}
+	}
+	return ""
+}
+
+// pathMatch reports whether pkg is prefix, or is a package somewhere
+// inside the tree rooted at prefix. Matching stops at "/" boundaries, so
+// "pkg/p1" matches "pkg/p1" and "pkg/p1/sub" but not "pkg/p19" or
+// "pkg/p1x".
+func pathMatch(pkg, prefix string) bool {
+	return pkg == prefix || strings.HasPrefix(pkg, prefix+"/")
+}